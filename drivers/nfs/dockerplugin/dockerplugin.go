@@ -0,0 +1,406 @@
+// Package dockerplugin exposes the nfs driver as a native Docker Volume
+// Plugin (https://docs.docker.com/engine/extend/plugins_volume/) over a
+// Unix domain socket, so that volumes can be created with
+// `docker volume create -d openstorage-nfs`.
+package dockerplugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/libopenstorage/openstorage/api"
+	"github.com/libopenstorage/openstorage/drivers/nfs"
+	"github.com/libopenstorage/openstorage/volume"
+)
+
+const (
+	pluginName  = "openstorage-nfs"
+	socketPath  = "/run/docker/plugins/" + pluginName + ".sock"
+	specPath    = "/etc/docker/plugins/" + pluginName + ".spec"
+	contentType = "application/vnd.docker.plugins.v1.1+json"
+	mountBase   = "/var/lib/openstorage/docker/volumes"
+)
+
+// mount tracks how many containers currently hold a volume mounted, so that
+// the last Unmount is the one that actually tears down the NFS mount.
+type mount struct {
+	id       api.VolumeID
+	path     string
+	refcount int
+}
+
+// Server implements the Docker Volume Plugin HTTP API on top of a
+// volume.VolumeDriver.
+type Server struct {
+	driver volume.VolumeDriver
+
+	mu     sync.Mutex
+	mounts map[string]*mount // keyed by Docker volume name
+
+	listener net.Listener
+}
+
+// New creates a Docker Volume Plugin server backed by the registered nfs
+// driver.
+func New() (*Server, error) {
+	d, err := volume.Get(nfs.Name)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		driver: d,
+		mounts: make(map[string]*mount),
+	}
+	if err := s.loadMounts(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// loadMounts seeds s.mounts from the driver's durable volume state, so that
+// a restarted plugin process still recognizes volumes it created before it
+// last exited instead of reporting them as not found.
+func (s *Server) loadMounts() error {
+	vols, err := s.driver.Enumerate(api.VolumeLocator{}, nil)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, vol := range vols {
+		path := ""
+		if vol.State == api.VolumeAttached {
+			path = vol.AttachPath
+		}
+		s.mounts[vol.Locator.Name] = &mount{id: vol.Id, path: path}
+	}
+	return nil
+}
+
+// Start listens on the plugin's Unix socket and installs the plugin spec so
+// the Docker daemon can discover it.
+func (s *Server) Start() error {
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0755); err != nil {
+		return err
+	}
+	os.Remove(socketPath)
+
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	s.listener = l
+
+	if err := installSpec(); err != nil {
+		l.Close()
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/Plugin.Activate", s.activate)
+	mux.HandleFunc("/VolumeDriver.Create", s.create)
+	mux.HandleFunc("/VolumeDriver.Remove", s.remove)
+	mux.HandleFunc("/VolumeDriver.Mount", s.mount)
+	mux.HandleFunc("/VolumeDriver.Path", s.path)
+	mux.HandleFunc("/VolumeDriver.Unmount", s.unmount)
+	mux.HandleFunc("/VolumeDriver.Get", s.get)
+	mux.HandleFunc("/VolumeDriver.List", s.list)
+	mux.HandleFunc("/VolumeDriver.Capabilities", s.capabilities)
+
+	log.Infof("Docker volume plugin %s listening on %s", pluginName, socketPath)
+	go http.Serve(l, mux)
+
+	return nil
+}
+
+// Stop closes the plugin socket.
+func (s *Server) Stop() error {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// installSpec writes the legacy plugin discovery file pointing the Docker
+// daemon at our Unix socket.
+func installSpec() error {
+	if err := os.MkdirAll(filepath.Dir(specPath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(specPath, []byte("unix://"+socketPath+"\n"), 0644)
+}
+
+type volumeRequest struct {
+	Name string
+	Opts map[string]string
+}
+
+type volumeResponse struct {
+	Mountpoint string           `json:"Mountpoint,omitempty"`
+	Volume     *dockerVolume    `json:"Volume,omitempty"`
+	Volumes    []*dockerVolume  `json:"Volumes,omitempty"`
+	Err        string           `json:"Err"`
+}
+
+type dockerVolume struct {
+	Name       string
+	Mountpoint string `json:",omitempty"`
+}
+
+type capabilitiesResponse struct {
+	Capabilities struct {
+		Scope string `json:"Scope"`
+	} `json:"Capabilities"`
+}
+
+func reply(w http.ResponseWriter, resp interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func replyErr(w http.ResponseWriter, err error) {
+	reply(w, &volumeResponse{Err: err.Error()})
+}
+
+func decode(r *http.Request, req *volumeRequest) error {
+	defer r.Body.Close()
+	return json.NewDecoder(r.Body).Decode(req)
+}
+
+// specFromOpts translates Docker's free-form Opts map into an api.VolumeSpec.
+func specFromOpts(opts map[string]string) *api.VolumeSpec {
+	spec := &api.VolumeSpec{
+		VolumeLabels: make(map[string]string),
+	}
+	for k, v := range opts {
+		switch k {
+		case "size":
+			fmt.Sscanf(v, "%d", &spec.Size)
+		case "fs":
+			spec.Format = api.Filesystem(v)
+		default:
+			spec.VolumeLabels[k] = v
+		}
+	}
+	return spec
+}
+
+func (s *Server) activate(w http.ResponseWriter, r *http.Request) {
+	reply(w, struct {
+		Implements []string
+	}{Implements: []string{"VolumeDriver"}})
+}
+
+func (s *Server) create(w http.ResponseWriter, r *http.Request) {
+	var req volumeRequest
+	if err := decode(r, &req); err != nil {
+		replyErr(w, err)
+		return
+	}
+
+	locator := api.VolumeLocator{Name: req.Name}
+	spec := specFromOpts(req.Opts)
+
+	id, err := s.driver.Create(locator, &api.CreateOptions{}, spec)
+	if err != nil {
+		replyErr(w, err)
+		return
+	}
+
+	s.mu.Lock()
+	s.mounts[req.Name] = &mount{id: id}
+	s.mu.Unlock()
+
+	reply(w, &volumeResponse{})
+}
+
+// lookup resolves a Docker volume name to its tracked mount, falling back to
+// the driver's durable state (and caching the result) if the local map
+// doesn't know about it yet, e.g. right after this process restarted.
+func (s *Server) lookup(name string) (*mount, error) {
+	s.mu.Lock()
+	m, ok := s.mounts[name]
+	s.mu.Unlock()
+	if ok {
+		return m, nil
+	}
+
+	vols, err := s.driver.Enumerate(api.VolumeLocator{Name: name}, nil)
+	if err != nil || len(vols) == 0 {
+		return nil, fmt.Errorf("volume %s not found", name)
+	}
+	vol := vols[0]
+
+	path := ""
+	if vol.State == api.VolumeAttached {
+		path = vol.AttachPath
+	}
+
+	s.mu.Lock()
+	m = &mount{id: vol.Id, path: path}
+	s.mounts[name] = m
+	s.mu.Unlock()
+
+	return m, nil
+}
+
+func (s *Server) remove(w http.ResponseWriter, r *http.Request) {
+	var req volumeRequest
+	if err := decode(r, &req); err != nil {
+		replyErr(w, err)
+		return
+	}
+
+	m, err := s.lookup(req.Name)
+	if err != nil {
+		replyErr(w, err)
+		return
+	}
+
+	if err := s.driver.Delete(m.id); err != nil {
+		replyErr(w, err)
+		return
+	}
+
+	s.mu.Lock()
+	delete(s.mounts, req.Name)
+	s.mu.Unlock()
+
+	reply(w, &volumeResponse{})
+}
+
+func (s *Server) mount(w http.ResponseWriter, r *http.Request) {
+	var req volumeRequest
+	if err := decode(r, &req); err != nil {
+		replyErr(w, err)
+		return
+	}
+
+	m, err := s.lookup(req.Name)
+	if err != nil {
+		replyErr(w, err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m.refcount == 0 {
+		mountpath := filepath.Join(mountBase, req.Name)
+		if err := os.MkdirAll(mountpath, 0755); err != nil {
+			replyErr(w, err)
+			return
+		}
+		if err := s.driver.Mount(m.id, mountpath); err != nil {
+			replyErr(w, err)
+			return
+		}
+		m.path = mountpath
+	}
+	m.refcount++
+
+	reply(w, &volumeResponse{Mountpoint: m.path})
+}
+
+func (s *Server) unmount(w http.ResponseWriter, r *http.Request) {
+	var req volumeRequest
+	if err := decode(r, &req); err != nil {
+		replyErr(w, err)
+		return
+	}
+
+	m, err := s.lookup(req.Name)
+	if err != nil {
+		replyErr(w, err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m.refcount > 0 {
+		m.refcount--
+	}
+	if m.refcount == 0 && m.path != "" {
+		if err := s.driver.Unmount(m.id, m.path); err != nil {
+			replyErr(w, err)
+			return
+		}
+		m.path = ""
+	}
+
+	reply(w, &volumeResponse{})
+}
+
+func (s *Server) path(w http.ResponseWriter, r *http.Request) {
+	var req volumeRequest
+	if err := decode(r, &req); err != nil {
+		replyErr(w, err)
+		return
+	}
+
+	m, err := s.lookup(req.Name)
+	if err != nil {
+		replyErr(w, err)
+		return
+	}
+
+	reply(w, &volumeResponse{Mountpoint: m.path})
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request) {
+	var req volumeRequest
+	if err := decode(r, &req); err != nil {
+		replyErr(w, err)
+		return
+	}
+
+	m, err := s.lookup(req.Name)
+	if err != nil {
+		replyErr(w, err)
+		return
+	}
+
+	if _, err := s.driver.Inspect([]api.VolumeID{m.id}); err != nil {
+		replyErr(w, err)
+		return
+	}
+
+	reply(w, &volumeResponse{Volume: &dockerVolume{Name: req.Name, Mountpoint: m.path}})
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	vols, err := s.driver.Enumerate(api.VolumeLocator{}, nil)
+	if err != nil {
+		replyErr(w, err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]*dockerVolume, 0, len(vols))
+	for _, vol := range vols {
+		mountpath := ""
+		if m, ok := s.mounts[vol.Locator.Name]; ok {
+			mountpath = m.path
+		}
+		out = append(out, &dockerVolume{Name: vol.Locator.Name, Mountpoint: mountpath})
+	}
+
+	reply(w, &volumeResponse{Volumes: out})
+}
+
+func (s *Server) capabilities(w http.ResponseWriter, r *http.Request) {
+	resp := &capabilitiesResponse{}
+	resp.Capabilities.Scope = "local"
+	reply(w, resp)
+}
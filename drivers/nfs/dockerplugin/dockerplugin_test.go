@@ -0,0 +1,190 @@
+package dockerplugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/libopenstorage/openstorage/api"
+	"github.com/libopenstorage/openstorage/volume"
+)
+
+// fakeDriver implements just the volume.VolumeDriver methods the plugin
+// actually calls; embedding the interface satisfies the rest so this stays
+// in lockstep with volume.VolumeDriver without having to stub every method.
+type fakeDriver struct {
+	volume.VolumeDriver
+
+	vols map[api.VolumeID]api.Volume
+
+	mountErr   error
+	unmountErr error
+}
+
+func newFakeDriver() *fakeDriver {
+	return &fakeDriver{vols: make(map[api.VolumeID]api.Volume)}
+}
+
+func (f *fakeDriver) Create(locator api.VolumeLocator, opt *api.CreateOptions, spec *api.VolumeSpec) (api.VolumeID, error) {
+	id := api.VolumeID(fmt.Sprintf("id-%d", len(f.vols)))
+	f.vols[id] = api.Volume{Id: id, Locator: locator, Spec: spec}
+	return id, nil
+}
+
+func (f *fakeDriver) Delete(id api.VolumeID) error {
+	if _, ok := f.vols[id]; !ok {
+		return fmt.Errorf("volume %s not found", id)
+	}
+	delete(f.vols, id)
+	return nil
+}
+
+func (f *fakeDriver) Mount(id api.VolumeID, mountpath string) error {
+	if f.mountErr != nil {
+		return f.mountErr
+	}
+	vol := f.vols[id]
+	vol.AttachPath = mountpath
+	vol.State = api.VolumeAttached
+	f.vols[id] = vol
+	return nil
+}
+
+func (f *fakeDriver) Unmount(id api.VolumeID, mountpath string) error {
+	if f.unmountErr != nil {
+		return f.unmountErr
+	}
+	vol := f.vols[id]
+	vol.AttachPath = ""
+	vol.State = api.VolumeAvailable
+	f.vols[id] = vol
+	return nil
+}
+
+func (f *fakeDriver) Inspect(ids []api.VolumeID) ([]api.Volume, error) {
+	out := make([]api.Volume, 0, len(ids))
+	for _, id := range ids {
+		vol, ok := f.vols[id]
+		if !ok {
+			return nil, fmt.Errorf("volume %s not found", id)
+		}
+		out = append(out, vol)
+	}
+	return out, nil
+}
+
+func (f *fakeDriver) Enumerate(locator api.VolumeLocator, labels api.Labels) ([]api.Volume, error) {
+	var out []api.Volume
+	for _, vol := range f.vols {
+		if locator.Name != "" && vol.Locator.Name != locator.Name {
+			continue
+		}
+		out = append(out, vol)
+	}
+	return out, nil
+}
+
+func newTestServer(d volume.VolumeDriver) *Server {
+	return &Server{driver: d, mounts: make(map[string]*mount)}
+}
+
+func doRequest(t *testing.T, handler http.HandlerFunc, req volumeRequest) *volumeResponse {
+	t.Helper()
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("Marshal request: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	var resp volumeResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return &resp
+}
+
+func TestCreateAndRemove(t *testing.T) {
+	s := newTestServer(newFakeDriver())
+
+	resp := doRequest(t, s.create, volumeRequest{Name: "vol1"})
+	if resp.Err != "" {
+		t.Fatalf("create: %s", resp.Err)
+	}
+
+	if _, err := s.lookup("vol1"); err != nil {
+		t.Fatalf("lookup after create: %v", err)
+	}
+
+	resp = doRequest(t, s.remove, volumeRequest{Name: "vol1"})
+	if resp.Err != "" {
+		t.Fatalf("remove: %s", resp.Err)
+	}
+
+	if _, err := s.lookup("vol1"); err == nil {
+		t.Fatal("expected vol1 to be gone after remove")
+	}
+}
+
+func TestGetUnknownVolume(t *testing.T) {
+	s := newTestServer(newFakeDriver())
+
+	resp := doRequest(t, s.get, volumeRequest{Name: "missing"})
+	if resp.Err == "" {
+		t.Fatal("expected an error for an unknown volume")
+	}
+}
+
+func TestLookupFallsBackToDriverEnumerate(t *testing.T) {
+	// Simulates a plugin restart: the volume exists in the driver's
+	// durable state but s.mounts starts out empty.
+	fd := newFakeDriver()
+	id, err := fd.Create(api.VolumeLocator{Name: "restored"}, &api.CreateOptions{}, &api.VolumeSpec{})
+	if err != nil {
+		t.Fatalf("fake Create: %v", err)
+	}
+
+	s := newTestServer(fd)
+
+	m, err := s.lookup("restored")
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if m.id != id {
+		t.Fatalf("got id %s, want %s", m.id, id)
+	}
+
+	// The result should now be cached locally too.
+	if _, ok := s.mounts["restored"]; !ok {
+		t.Fatal("lookup did not cache the volume found via Enumerate")
+	}
+}
+
+func TestList(t *testing.T) {
+	fd := newFakeDriver()
+	if _, err := fd.Create(api.VolumeLocator{Name: "vol1"}, &api.CreateOptions{}, &api.VolumeSpec{}); err != nil {
+		t.Fatalf("fake Create: %v", err)
+	}
+
+	s := newTestServer(fd)
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	w := httptest.NewRecorder()
+	s.list(w, r)
+
+	var resp volumeResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.Err != "" {
+		t.Fatalf("list: %s", resp.Err)
+	}
+	if len(resp.Volumes) != 1 || resp.Volumes[0].Name != "vol1" {
+		t.Fatalf("got %+v, want a single vol1 entry", resp.Volumes)
+	}
+}
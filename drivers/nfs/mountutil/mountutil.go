@@ -0,0 +1,107 @@
+// Package mountutil provides a small, testable wrapper around the mount
+// system calls the nfs driver depends on, in the spirit of
+// k8s.io/mount-utils: a narrow interface the driver can be constructed with
+// once at Init time, rather than shelling out or calling syscall.Mount
+// directly from business logic.
+package mountutil
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// Interface is the subset of mount operations the nfs driver needs.
+type Interface interface {
+	// Mount mounts source onto target using fstype, with the given mount
+	// options (e.g. "vers=4", "ro").
+	Mount(source, target, fstype string, options []string) error
+	// MountImage loop-mounts the regular file at image onto target.
+	MountImage(image, target, fstype string) error
+	// Unmount unmounts target.
+	Unmount(target string) error
+	// IsMountPoint reports whether path is currently a mount point.
+	IsMountPoint(path string) (bool, error)
+	// IsCorruptedMountError reports whether err indicates a mount that is
+	// present but unusable, e.g. a stale NFS file handle, as opposed to a
+	// transient or permission error.
+	IsCorruptedMountError(err error) bool
+}
+
+type mounter struct{}
+
+// New returns the default, syscall-backed Interface implementation.
+func New() Interface {
+	return &mounter{}
+}
+
+func (m *mounter) Mount(source, target, fstype string, options []string) error {
+	return syscall.Mount(source, target, fstype, 0, strings.Join(options, ","))
+}
+
+// MountImage shells out to mount(8), which takes care of attaching the
+// image to a free loop device before mounting it; doing that ourselves
+// would mean reimplementing the LOOP_SET_FD/LOOP_CTL_GET_FREE ioctl dance
+// that losetup already wraps.
+func (m *mounter) MountImage(image, target, fstype string) error {
+	args := []string{"-o", "loop"}
+	if fstype != "" {
+		args = append(args, "-t", fstype)
+	}
+	args = append(args, image, target)
+
+	out, err := exec.Command("mount", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("mountutil: loop-mounting %s on %s: %v: %s", image, target, err, out)
+	}
+	return nil
+}
+
+func (m *mounter) Unmount(target string) error {
+	return syscall.Unmount(target, 0)
+}
+
+func (m *mounter) IsMountPoint(path string) (bool, error) {
+	out, err := exec.Command("mountpoint", "-q", path).CombinedOutput()
+	if err == nil {
+		return true, nil
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	return false, fmt.Errorf("mountutil: checking %s: %v: %s", path, err, out)
+}
+
+// corruptedMountMessages are substrings of error text that the "mountpoint"
+// and mount/umount tools emit for a volume whose server has gone away or
+// whose file handle is stale, as opposed to a normal "not mounted" result.
+var corruptedMountMessages = []string{
+	"stale nfs file handle",
+	"stale file handle",
+	"transport endpoint is not connected",
+	"no such device",
+	"input/output error",
+}
+
+func (m *mounter) IsCorruptedMountError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if pe, ok := err.(*os.PathError); ok {
+		switch pe.Err {
+		case syscall.ESTALE, syscall.ENOTCONN, syscall.EIO, syscall.ENODEV:
+			return true
+		}
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, m := range corruptedMountMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
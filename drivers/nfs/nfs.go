@@ -1,16 +1,19 @@
 package nfs
 
 import (
-	"errors"
+	"encoding/json"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
-	"syscall"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/google/uuid"
 
 	"github.com/libopenstorage/kvdb"
 	"github.com/libopenstorage/openstorage/api"
+	apierrors "github.com/libopenstorage/openstorage/api/errors"
+	"github.com/libopenstorage/openstorage/drivers/nfs/mountutil"
 	"github.com/libopenstorage/openstorage/volume"
 )
 
@@ -23,66 +26,107 @@ var (
 	devMinor int32
 )
 
-// This data is persisted in a DB.
+// This data is persisted in a DB. Fields are exported so that
+// encoding/json (used both by kvdb and by our own kvdb.Enumerate scans in
+// this package) can actually populate them; json.Marshal/Unmarshal silently
+// skip unexported fields.
 type awsVolume struct {
-	spec      api.VolumeSpec
-	formatted bool
-	attached  bool
-	mounted   bool
-	device    string
-	mountpath string
+	Locator   api.VolumeLocator
+	Spec      api.VolumeSpec
+	Formatted bool
+	Attached  bool
+	Mounted   bool
+	Device    string
+	Mountpath string
+	// URI is the NFS server of the pool this volume was placed on.
+	URI string
+	// SeedURL and SeedDigest record the provenance of a volume that was
+	// populated from an api.Source.Seed, so Inspect can report where its
+	// data came from.
+	SeedURL    string
+	SeedDigest string
+	// Pool is the root path of the export this volume was placed on.
+	Pool string
+	// LoopImage is set when spec.Size couldn't be enforced with a project
+	// quota and was instead backed by a sparse image file loop-mounted on
+	// Mount.
+	LoopImage string
+}
+
+// nfsSnapshot is the metadata persisted for a point-in-time copy of a
+// volume's directory tree.
+type nfsSnapshot struct {
+	Id       api.SnapID
+	VolumeID api.VolumeID
+	Path     string
+	Labels   api.Labels
 }
 
 // Implements the open storage volume interface.
 type nfsDriver struct {
 	volume.DefaultBlockDriver
-	db        kvdb.Kvdb
-	nfsServer string
-	mntPath   string
+	db      kvdb.Kvdb
+	pools   []nfsPool
+	mounter mountutil.Interface
 }
 
 func Init(params volume.DriverParams) (volume.VolumeDriver, error) {
-	uri, ok := params["uri"]
-	if !ok {
-		return nil, errors.New("No NFS server URI provided")
-	}
-
-	log.Println("NFS driver initializing with server: ", uri)
-
-	out, err := exec.Command("uuidgen").Output()
+	pools, err := parsePools(params)
 	if err != nil {
 		return nil, err
 	}
-	uuid := string(out)
-	uuid = strings.TrimSuffix(uuid, "\n")
-
-	inst := &nfsDriver{
-		db:        kvdb.Instance(),
-		mntPath:   "/mnt/" + uuid,
-		nfsServer: uri}
 
-	err = os.MkdirAll(inst.mntPath, 0744)
-	if err != nil {
-		return nil, err
+	vers := params["vers"]
+	if vers == "" {
+		vers = "4"
+	}
+	fstype := "nfs"
+	if strings.HasPrefix(vers, "4") {
+		fstype = "nfs4"
+	}
+	options := []string{"vers=" + vers}
+	if extra := params["options"]; extra != "" {
+		options = append(options, strings.Split(extra, ",")...)
 	}
 
-	log.Println("Binding NFS server to:", inst.mntPath)
+	mounter := mountutil.New()
+	for i := range pools {
+		p := &pools[i]
+		if p.Path == "" {
+			p.Path = "/mnt/" + uuid.New().String()
+		}
 
-	// Mount the nfs server locally on a unique path.
-	err = syscall.Mount(inst.nfsServer, inst.mntPath, "tmpfs", 0, "mode=0700,uid=65534")
-	if err != nil {
-		os.Remove(inst.mntPath)
-		return nil, err
+		log.Println("NFS driver initializing pool", p.ServiceLevel, "with server:", p.URI)
+
+		if err := os.MkdirAll(p.Path, 0744); err != nil {
+			return nil, err
+		}
+
+		// Mount the export locally on the pool's path, using an nfs/nfs4
+		// vers option (default nfs4) so that unqualified server URIs still
+		// negotiate a sane protocol version.
+		if err := mounter.Mount(p.URI, p.Path, fstype, options); err != nil {
+			os.Remove(p.Path)
+			return nil, err
+		}
+
+		log.Println("NFS pool mounted at:", p.Path)
 	}
 
-	log.Println("NFS initialized and driver mounted at: ", inst.mntPath)
-	return inst, nil
+	return &nfsDriver{
+		db:      kvdb.Instance(),
+		pools:   pools,
+		mounter: mounter,
+	}, nil
 }
 
 func (d *nfsDriver) get(volumeID string) (*awsVolume, error) {
 	v := &awsVolume{}
 	key := NfsDBKey + "/" + volumeID
 	_, err := d.db.GetVal(key, v)
+	if err == kvdb.ErrNotFound {
+		return nil, &apierrors.ErrNotFound{ID: volumeID, Type: "volume"}
+	}
 	return v, err
 }
 
@@ -102,28 +146,122 @@ func (d *nfsDriver) String() string {
 }
 
 func (d *nfsDriver) Create(l api.VolumeLocator, opt *api.CreateOptions, spec *api.VolumeSpec) (api.VolumeID, error) {
-	out, err := exec.Command("uuidgen").Output()
+	volumeID := uuid.New().String()
+
+	pool, err := d.pickPool(spec)
 	if err != nil {
 		return "", err
 	}
-	volumeID := string(out)
-	volumeID = strings.TrimSuffix(volumeID, "\n")
 
-	// Create a directory on the NFS server with this UUID.
-	err = os.MkdirAll(d.mntPath+volumeID, 0744)
-	if err != nil {
-		return "", err
+	path := pool.Path + "/" + volumeID
+	v := &awsVolume{Locator: l, Device: path, Spec: *spec, Pool: pool.Path, URI: pool.URI}
+
+	switch {
+	case spec != nil && spec.Source != nil && spec.Source.Parent != "":
+		// Clone: seed the new volume from an existing snapshot's directory
+		// tree instead of creating an empty one.
+		if err := d.clone(api.SnapID(spec.Source.Parent), path); err != nil {
+			return "", err
+		}
+	case spec != nil && spec.Source != nil && spec.Source.Seed != "":
+		// Seed: populate the new volume from a template dataset.
+		digest, err := seedVolume(path, spec.Source.Seed)
+		if err != nil {
+			return "", err
+		}
+		v.SeedURL = spec.Source.Seed
+		v.SeedDigest = digest
+	default:
+		// Create a directory on the NFS server with this UUID.
+		if err := os.MkdirAll(path, 0744); err != nil {
+			return "", err
+		}
+	}
+
+	// Enforce spec.Size regardless of how path was populated above: a
+	// clone or a seeded volume is just as subject to its quota as a plain
+	// empty one.
+	if spec != nil && spec.Size > 0 {
+		if err := applyQuota(path, spec.Size); err != nil {
+			// Project quotas aren't available on this export; fall back to
+			// a sparse image of the requested size that gets loop-mounted
+			// in place of the plain directory on Mount.
+			image := path + ".img"
+			if err := createLoopImage(image, spec.Size, string(spec.Format)); err != nil {
+				return "", err
+			}
+
+			hasData, err := dirHasEntries(path)
+			if err != nil {
+				return "", err
+			}
+			if hasData {
+				// path was already populated by a clone or seed; carry
+				// that data into the image instead of discarding it.
+				if err := seedLoopImage(image, string(spec.Format), path); err != nil {
+					return "", err
+				}
+				// Now that the data lives in image, path would just be an
+				// unreferenced duplicate of it.
+				if err := os.RemoveAll(path); err != nil {
+					return "", err
+				}
+			}
+
+			v.LoopImage = image
+		}
 	}
 
 	// Persist the volume spec.  We use this for all subsequent operations on
 	// this volume ID.
-	err = d.put(volumeID, &awsVolume{device: d.mntPath + volumeID, spec: *spec})
+	err = d.put(volumeID, v)
 
 	return api.VolumeID(volumeID), err
 }
 
+// clone seeds dest with a copy of the snapshot identified by snapID.
+func (d *nfsDriver) clone(snapID api.SnapID, dest string) error {
+	snap, err := d.getSnap(snapID)
+	if err != nil {
+		return err
+	}
+	return cloneDir(snap.Path, dest)
+}
+
 func (d *nfsDriver) Inspect(volumeIDs []api.VolumeID) ([]api.Volume, error) {
-	return nil, nil
+	vols := make([]api.Volume, 0, len(volumeIDs))
+	for _, id := range volumeIDs {
+		v, err := d.get(string(id))
+		if err != nil {
+			return nil, err
+		}
+		vols = append(vols, d.toAPIVolume(id, v))
+	}
+	return vols, nil
+}
+
+// toAPIVolume assembles the api.Volume view of a persisted awsVolume,
+// reporting its current mount state, size, device path, and NFS server URI.
+func (d *nfsDriver) toAPIVolume(id api.VolumeID, v *awsVolume) api.Volume {
+	vol := api.Volume{
+		Id:         id,
+		Locator:    v.Locator,
+		Spec:       &v.Spec,
+		DevicePath: v.Device,
+		Source: &api.Source{
+			Seed: v.SeedURL,
+		},
+		Labels: api.Labels{"nfsServerURI": v.URI},
+	}
+
+	if v.Mounted {
+		vol.State = api.VolumeAttached
+		vol.AttachPath = v.Mountpath
+	} else {
+		vol.State = api.VolumeAvailable
+	}
+
+	return vol
 }
 
 func (d *nfsDriver) Delete(volumeID api.VolumeID) error {
@@ -132,31 +270,122 @@ func (d *nfsDriver) Delete(volumeID api.VolumeID) error {
 		return err
 	}
 
-	// Delete the directory on the nfs server.
-	err = os.Remove(v.device)
+	// Delete the directory on the nfs server. A clone or seeded volume's
+	// directory is non-empty, so this has to be recursive, same as
+	// SnapDelete's os.RemoveAll(snap.Path) below.
+	err = os.RemoveAll(v.Device)
 	if err != nil {
 		return err
 	}
 
+	if v.LoopImage != "" {
+		if err := os.Remove(v.LoopImage); err != nil {
+			return err
+		}
+	}
+
 	d.del(string(volumeID))
 
 	return nil
 }
 
 func (d *nfsDriver) Snapshot(volumeID api.VolumeID, labels api.Labels) (api.SnapID, error) {
-	return "", volume.ErrNotSupported
+	v, err := d.get(string(volumeID))
+	if err != nil {
+		return "", err
+	}
+
+	snapID := api.SnapID(uuid.New().String())
+
+	snapPath := v.Pool + "/.snaps/" + string(snapID)
+	if err := cloneDir(v.Device, snapPath); err != nil {
+		return "", err
+	}
+
+	snap := &nfsSnapshot{
+		Id:       snapID,
+		VolumeID: volumeID,
+		Path:     snapPath,
+		Labels:   labels,
+	}
+	if err := d.putSnap(snapID, snap); err != nil {
+		return "", err
+	}
+
+	return snapID, nil
 }
 
 func (d *nfsDriver) SnapDelete(snapID api.SnapID) error {
-	return volume.ErrNotSupported
+	snap, err := d.getSnap(snapID)
+	if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(snap.Path); err != nil {
+		return err
+	}
+
+	d.delSnap(snapID)
+	return nil
+}
+
+func (d *nfsDriver) SnapInspect(snapIDs []api.SnapID) ([]api.VolumeSnap, error) {
+	snaps := make([]api.VolumeSnap, 0, len(snapIDs))
+	for _, id := range snapIDs {
+		snap, err := d.getSnap(id)
+		if err != nil {
+			return nil, err
+		}
+		snaps = append(snaps, api.VolumeSnap{
+			Id:       snap.Id,
+			VolumeID: snap.VolumeID,
+			Labels:   snap.Labels,
+		})
+	}
+	return snaps, nil
+}
+
+func (d *nfsDriver) snapKey(snapID api.SnapID) string {
+	return NfsDBKey + "/snap/" + string(snapID)
+}
+
+func (d *nfsDriver) getSnap(snapID api.SnapID) (*nfsSnapshot, error) {
+	s := &nfsSnapshot{}
+	key := d.snapKey(snapID)
+	_, err := d.db.GetVal(key, s)
+	return s, err
+}
+
+func (d *nfsDriver) putSnap(snapID api.SnapID, s *nfsSnapshot) error {
+	key := d.snapKey(snapID)
+	_, err := d.db.Put(key, s, 0)
+	return err
 }
 
-func (d *nfsDriver) SnapInspect(snapID []api.SnapID) ([]api.VolumeSnap, error) {
-	return []api.VolumeSnap{}, volume.ErrNotSupported
+func (d *nfsDriver) delSnap(snapID api.SnapID) {
+	key := d.snapKey(snapID)
+	d.db.Delete(key)
 }
 
-func (d *nfsDriver) Stats(volumeID api.VolumeID) (api.VolumeStats, error) {
-	return api.VolumeStats{}, volume.ErrNotSupported
+// cloneDir populates dst with a point-in-time copy of src, preferring a
+// reflink (copy-on-write) copy and falling back to a hardlink-based copy
+// when the underlying filesystem doesn't support FICLONE.
+func cloneDir(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0744); err != nil {
+		return err
+	}
+
+	if err := exec.Command("cp", "--reflink=always", "-a", src, dst).Run(); err == nil {
+		return nil
+	}
+
+	// A failed reflink copy can leave dst partially created, which would make
+	// the fallback copy src into dst/<base of src> instead of onto dst.
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+
+	return exec.Command("cp", "-al", src, dst).Run()
 }
 
 func (d *nfsDriver) Alerts(volumeID api.VolumeID) (api.VolumeAlerts, error) {
@@ -164,11 +393,71 @@ func (d *nfsDriver) Alerts(volumeID api.VolumeID) (api.VolumeAlerts, error) {
 }
 
 func (d *nfsDriver) Enumerate(locator api.VolumeLocator, labels api.Labels) ([]api.Volume, error) {
-	return []api.Volume{}, volume.ErrNotSupported
+	kvPairs, err := d.db.Enumerate(NfsDBKey + "/")
+	if err != nil {
+		return nil, err
+	}
+
+	vols := make([]api.Volume, 0, len(kvPairs))
+	for _, kvp := range kvPairs {
+		id := strings.TrimPrefix(kvp.Key, NfsDBKey+"/")
+		if strings.HasPrefix(id, "snap/") {
+			// Snapshot metadata lives under the same prefix; skip it here.
+			continue
+		}
+
+		v := &awsVolume{}
+		if err := json.Unmarshal(kvp.Value, v); err != nil {
+			return nil, err
+		}
+
+		if locator.Name != "" && v.Locator.Name != locator.Name {
+			continue
+		}
+		if !labelsMatch(locator.VolumeLabels, v.Locator.VolumeLabels) {
+			continue
+		}
+		if !labelsMatch(labels, v.Locator.VolumeLabels) {
+			continue
+		}
+
+		vols = append(vols, d.toAPIVolume(api.VolumeID(id), v))
+	}
+	return vols, nil
 }
 
 func (d *nfsDriver) SnapEnumerate(locator api.VolumeLocator, labels api.Labels) ([]api.VolumeSnap, error) {
-	return nil, volume.ErrNotSupported
+	kvPairs, err := d.db.Enumerate(NfsDBKey + "/snap/")
+	if err != nil {
+		return nil, err
+	}
+
+	snaps := make([]api.VolumeSnap, 0, len(kvPairs))
+	for _, kvp := range kvPairs {
+		snap := &nfsSnapshot{}
+		if err := json.Unmarshal(kvp.Value, snap); err != nil {
+			return nil, err
+		}
+		if !labelsMatch(labels, snap.Labels) {
+			continue
+		}
+		snaps = append(snaps, api.VolumeSnap{
+			Id:       snap.Id,
+			VolumeID: snap.VolumeID,
+			Labels:   snap.Labels,
+		})
+	}
+	return snaps, nil
+}
+
+// labelsMatch returns true if every key/value in filter is present in have.
+func labelsMatch(filter, have api.Labels) bool {
+	for k, v := range filter {
+		if have[k] != v {
+			return false
+		}
+	}
+	return true
 }
 
 func (d *nfsDriver) Mount(volumeID api.VolumeID, mountpath string) error {
@@ -177,13 +466,29 @@ func (d *nfsDriver) Mount(volumeID api.VolumeID, mountpath string) error {
 		return err
 	}
 
-	err = syscall.Mount(v.device, mountpath, string(v.spec.Format), 0, "")
+	mountOnce := func() error {
+		if v.LoopImage != "" {
+			return d.mounter.MountImage(v.LoopImage, mountpath, string(v.Spec.Format))
+		}
+		return d.mounter.Mount(v.Device, mountpath, string(v.Spec.Format), nil)
+	}
+
+	err = mountOnce()
+	if err != nil && d.mounter.IsCorruptedMountError(err) {
+		// A stale handle from a previous mount at this path; force it loose
+		// and retry once instead of surfacing the raw syscall error.
+		log.Warnf("%s: recovering corrupted mount at %s: %v", Name, mountpath, err)
+		if uerr := d.mounter.Unmount(mountpath); uerr != nil {
+			return uerr
+		}
+		err = mountOnce()
+	}
 	if err != nil {
 		return err
 	}
 
-	v.mountpath = mountpath
-	v.mounted = true
+	v.Mountpath = mountpath
+	v.Mounted = true
 	err = d.put(string(volumeID), v)
 
 	return err
@@ -195,13 +500,24 @@ func (d *nfsDriver) Unmount(volumeID api.VolumeID, mountpath string) error {
 		return err
 	}
 
-	err = syscall.Unmount(v.mountpath, 0)
-	if err != nil {
+	mounted, err := d.mounter.IsMountPoint(v.Mountpath)
+	if err != nil && !d.mounter.IsCorruptedMountError(err) {
+		return err
+	}
+	if err == nil && !mounted {
+		// Already unmounted, e.g. the server disappeared out from under us;
+		// just clear our state.
+		v.Mountpath = ""
+		v.Mounted = false
+		return d.put(string(volumeID), v)
+	}
+
+	if err := d.mounter.Unmount(v.Mountpath); err != nil {
 		return err
 	}
 
-	v.mountpath = ""
-	v.mounted = false
+	v.Mountpath = ""
+	v.Mounted = false
 	err = d.put(string(volumeID), v)
 
 	return err
@@ -0,0 +1,42 @@
+package nfs
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/libopenstorage/openstorage/api"
+)
+
+// TestAwsVolumeJSONRoundTrip guards against awsVolume regressing back to
+// unexported fields: encoding/json silently drops those, which would make
+// every field come back zeroed after a kvdb Put/GetVal or our own
+// Enumerate scan.
+func TestAwsVolumeJSONRoundTrip(t *testing.T) {
+	in := &awsVolume{
+		Locator:    api.VolumeLocator{Name: "test-volume"},
+		Spec:       api.VolumeSpec{Size: 42},
+		Mounted:    true,
+		Device:     "/mnt/pool/test-volume",
+		Mountpath:  "/var/lib/openstorage/docker/volumes/test-volume",
+		URI:        "nfs://server/export",
+		SeedURL:    "file:///golden/dataset.tar.gz",
+		SeedDigest: "deadbeef",
+		Pool:       "/mnt/pool",
+		LoopImage:  "/mnt/pool/test-volume.img",
+	}
+
+	raw, err := json.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	out := &awsVolume{}
+	if err := json.Unmarshal(raw, out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	if !reflect.DeepEqual(out, in) {
+		t.Fatalf("round trip mismatch: got %+v, want %+v", out, in)
+	}
+}
@@ -0,0 +1,71 @@
+package nfs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/libopenstorage/openstorage/api"
+	"github.com/libopenstorage/openstorage/volume"
+)
+
+// nfsPool describes a single NFS export the driver can place volumes on,
+// modeled after Trident's backend pools: a driver is configured with a list
+// of these rather than a single server URI, and Create picks one per
+// volume based on the requested labels.
+type nfsPool struct {
+	URI          string `json:"uri"`
+	Path         string `json:"path"`
+	ServiceLevel string `json:"serviceLevel"`
+	Region       string `json:"region"`
+}
+
+// parsePools builds the pool list a driver instance should mount from its
+// init params. A JSON-encoded "pools" param selects the multi-export form;
+// a bare "uri" param is kept working as a single, unlabeled pool.
+func parsePools(params volume.DriverParams) ([]nfsPool, error) {
+	if raw, ok := params["pools"]; ok && raw != "" {
+		var pools []nfsPool
+		if err := json.Unmarshal([]byte(raw), &pools); err != nil {
+			return nil, fmt.Errorf("invalid pools configuration: %v", err)
+		}
+		if len(pools) == 0 {
+			return nil, errors.New("pools configuration is empty")
+		}
+		return pools, nil
+	}
+
+	uri, ok := params["uri"]
+	if !ok {
+		return nil, errors.New("No NFS server URI provided")
+	}
+	return []nfsPool{{URI: uri}}, nil
+}
+
+// pickPool selects the pool a new volume should be placed on, matching
+// spec.VolumeLabels' "serviceLevel" and "region" against the configured
+// pools. A spec with neither label falls back to the first pool.
+func (d *nfsDriver) pickPool(spec *api.VolumeSpec) (*nfsPool, error) {
+	var level, region string
+	if spec != nil {
+		level = spec.VolumeLabels["serviceLevel"]
+		region = spec.VolumeLabels["region"]
+	}
+
+	if level == "" && region == "" {
+		return &d.pools[0], nil
+	}
+
+	for i := range d.pools {
+		p := &d.pools[i]
+		if level != "" && p.ServiceLevel != level {
+			continue
+		}
+		if region != "" && p.Region != region {
+			continue
+		}
+		return p, nil
+	}
+
+	return nil, fmt.Errorf("no nfs pool matches serviceLevel=%q region=%q", level, region)
+}
@@ -0,0 +1,80 @@
+package nfs
+
+import (
+	"testing"
+
+	"github.com/libopenstorage/openstorage/api"
+	"github.com/libopenstorage/openstorage/volume"
+)
+
+func TestParsePoolsLegacyURI(t *testing.T) {
+	pools, err := parsePools(volume.DriverParams{"uri": "nfs://server/export"})
+	if err != nil {
+		t.Fatalf("parsePools: %v", err)
+	}
+	if len(pools) != 1 || pools[0].URI != "nfs://server/export" {
+		t.Fatalf("got %+v, want a single pool with the legacy uri", pools)
+	}
+}
+
+func TestParsePoolsJSON(t *testing.T) {
+	params := volume.DriverParams{"pools": `[
+		{"uri": "nfs://fast/export", "path": "/mnt/fast", "serviceLevel": "gold"},
+		{"uri": "nfs://slow/export", "path": "/mnt/slow", "serviceLevel": "bronze"}
+	]`}
+	pools, err := parsePools(params)
+	if err != nil {
+		t.Fatalf("parsePools: %v", err)
+	}
+	if len(pools) != 2 || pools[0].ServiceLevel != "gold" || pools[1].ServiceLevel != "bronze" {
+		t.Fatalf("got %+v, want the two configured pools", pools)
+	}
+}
+
+func TestParsePoolsMissingConfig(t *testing.T) {
+	if _, err := parsePools(volume.DriverParams{}); err == nil {
+		t.Fatal("expected an error when neither pools nor uri is set")
+	}
+}
+
+func TestParsePoolsEmptyJSON(t *testing.T) {
+	if _, err := parsePools(volume.DriverParams{"pools": "[]"}); err == nil {
+		t.Fatal("expected an error for an empty pools array")
+	}
+}
+
+func TestPickPoolDefaultsToFirst(t *testing.T) {
+	d := &nfsDriver{pools: []nfsPool{{Path: "/mnt/a"}, {Path: "/mnt/b"}}}
+	p, err := d.pickPool(&api.VolumeSpec{})
+	if err != nil {
+		t.Fatalf("pickPool: %v", err)
+	}
+	if p.Path != "/mnt/a" {
+		t.Fatalf("got pool %+v, want the first configured pool", p)
+	}
+}
+
+func TestPickPoolMatchesLabels(t *testing.T) {
+	d := &nfsDriver{pools: []nfsPool{
+		{Path: "/mnt/gold-us", ServiceLevel: "gold", Region: "us"},
+		{Path: "/mnt/gold-eu", ServiceLevel: "gold", Region: "eu"},
+		{Path: "/mnt/bronze-us", ServiceLevel: "bronze", Region: "us"},
+	}}
+
+	spec := &api.VolumeSpec{VolumeLabels: map[string]string{"serviceLevel": "gold", "region": "eu"}}
+	p, err := d.pickPool(spec)
+	if err != nil {
+		t.Fatalf("pickPool: %v", err)
+	}
+	if p.Path != "/mnt/gold-eu" {
+		t.Fatalf("got pool %+v, want the gold/eu pool", p)
+	}
+}
+
+func TestPickPoolNoMatch(t *testing.T) {
+	d := &nfsDriver{pools: []nfsPool{{Path: "/mnt/a", ServiceLevel: "gold"}}}
+	spec := &api.VolumeSpec{VolumeLabels: map[string]string{"serviceLevel": "platinum"}}
+	if _, err := d.pickPool(spec); err == nil {
+		t.Fatal("expected an error when no pool matches the requested labels")
+	}
+}
@@ -0,0 +1,149 @@
+package nfs
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"github.com/libopenstorage/openstorage/api"
+	"github.com/libopenstorage/openstorage/drivers/nfs/mountutil"
+)
+
+// errQuotaUnavailable is returned by applyQuota when neither xfs_quota nor
+// setquota is usable against dir, so the caller should fall back to a
+// loop-mounted sparse image instead.
+var errQuotaUnavailable = errors.New("project quotas unavailable on this export")
+
+// applyQuota caps dir at sizeBytes using a project quota, trying xfs_quota
+// first (for XFS exports) and falling back to the generic ext4 project
+// quota tools (setquota, via chattr +P) otherwise.
+func applyQuota(dir string, sizeBytes uint64) error {
+	if sizeBytes == 0 {
+		return nil
+	}
+
+	limit := fmt.Sprintf("limit -p bhard=%d %s", sizeBytes, dir)
+	if err := exec.Command("xfs_quota", "-x", "-c", limit, dir).Run(); err == nil {
+		return nil
+	}
+
+	if err := setExtProjectQuota(dir, sizeBytes); err == nil {
+		return nil
+	}
+
+	return errQuotaUnavailable
+}
+
+// setExtProjectQuota enforces sizeBytes on dir using ext4 project quotas.
+// setquota's -P flag takes a numeric project id, not a path, and that id
+// has to already be assigned to dir (with quota inheritance, so files
+// created under it stay in the same project) before the limit means
+// anything; chattr -p does that assignment and +P sets the inheritance
+// flag. The filesystem argument is the
+// export's local mountpoint, which our layout keeps one level up from the
+// volume directory.
+func setExtProjectQuota(dir string, sizeBytes uint64) error {
+	projID := projectID(dir)
+
+	if err := exec.Command("chattr", "-R", "-p", fmt.Sprintf("%d", projID), "+P", dir).Run(); err != nil {
+		return err
+	}
+
+	fs := filepath.Dir(dir)
+	blocks := fmt.Sprintf("%d", sizeBytes/1024)
+	return exec.Command("setquota", "-P", fmt.Sprintf("%d", projID), "0", blocks, "0", "0", fs).Run()
+}
+
+// projectID derives a stable, non-reserved ext4 project id from a volume's
+// directory path so repeated calls for the same volume agree on the id.
+func projectID(dir string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(dir))
+	return h.Sum32()%1000000 + 1000
+}
+
+// createLoopImage creates a sparse, fstype-formatted file of sizeBytes at
+// path, to be loop-mounted in place of a plain directory when the export
+// doesn't support project quotas.
+func createLoopImage(path string, sizeBytes uint64, fstype string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(int64(sizeBytes)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if fstype == "" {
+		fstype = "ext4"
+	}
+	return exec.Command("mkfs."+fstype, path).Run()
+}
+
+// dirHasEntries reports whether path contains any files, i.e. whether it
+// was already populated (by a clone or a seed) before quota enforcement
+// decided to move it into a loop image.
+func dirHasEntries(path string) (bool, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return false, err
+	}
+	return len(entries) > 0, nil
+}
+
+// seedLoopImage copies src's contents into image, an already-formatted
+// loop image, by mounting it at a temporary path just long enough to
+// populate it. This is how a clone or seed's data survives falling back
+// to a loop-mounted quota image instead of a plain directory.
+func seedLoopImage(image, fstype, src string) error {
+	tmp, err := os.MkdirTemp("", "nfs-loopseed-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	m := mountutil.New()
+	if err := m.MountImage(image, tmp, fstype); err != nil {
+		return err
+	}
+	defer m.Unmount(tmp)
+
+	return exec.Command("cp", "-a", src+"/.", tmp).Run()
+}
+
+func (d *nfsDriver) Stats(volumeID api.VolumeID) (api.VolumeStats, error) {
+	v, err := d.get(string(volumeID))
+	if err != nil {
+		return api.VolumeStats{}, err
+	}
+
+	target := v.Device
+	if v.LoopImage != "" {
+		if v.Mountpath == "" {
+			return api.VolumeStats{}, fmt.Errorf("volume %s is not mounted", volumeID)
+		}
+		target = v.Mountpath
+	}
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(target, &stat); err != nil {
+		return api.VolumeStats{}, err
+	}
+
+	blockSize := uint64(stat.Bsize)
+	total := stat.Blocks * blockSize
+	free := stat.Bfree * blockSize
+
+	return api.VolumeStats{
+		Used:      total - free,
+		Available: free,
+	}, nil
+}
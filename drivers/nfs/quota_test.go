@@ -0,0 +1,44 @@
+package nfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProjectIDIsStableAndNonReserved(t *testing.T) {
+	id := projectID("/mnt/pool/some-volume")
+	if id != projectID("/mnt/pool/some-volume") {
+		t.Fatal("projectID is not stable across calls for the same path")
+	}
+	if id < 1000 {
+		t.Fatalf("projectID %d falls in the reserved low range", id)
+	}
+	if projectID("/mnt/pool/other-volume") == id {
+		t.Fatal("projectID collided for two different volume paths (unlucky, but check the hash)")
+	}
+}
+
+func TestDirHasEntries(t *testing.T) {
+	dir := t.TempDir()
+
+	hasData, err := dirHasEntries(dir)
+	if err != nil {
+		t.Fatalf("dirHasEntries: %v", err)
+	}
+	if hasData {
+		t.Fatal("empty directory reported as having entries")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "seeded.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	hasData, err = dirHasEntries(dir)
+	if err != nil {
+		t.Fatalf("dirHasEntries: %v", err)
+	}
+	if !hasData {
+		t.Fatal("populated directory reported as empty")
+	}
+}
@@ -0,0 +1,174 @@
+package nfs
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// gzipMagic is the two-byte header every gzip stream starts with.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// seedVolume populates path with the tarball referenced by seedURL and
+// returns its sha256 digest.  The payload is extracted into a sibling
+// staging directory and atomically renamed into place on success, so a
+// failed or partial seed never leaves a half-populated volume behind.
+func seedVolume(path, seedURL string) (string, error) {
+	r, err := openSeed(seedURL)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	staging := path + ".seeding"
+	if err := os.MkdirAll(staging, 0744); err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	br := bufio.NewReader(io.TeeReader(r, h))
+
+	// A seed can be a plain .tar or a gzip-compressed .tar.gz; sniff the
+	// magic bytes rather than assuming compression.
+	tarStream, err := maybeGunzip(br)
+	if err != nil {
+		os.RemoveAll(staging)
+		return "", err
+	}
+	if gz, ok := tarStream.(*gzip.Reader); ok {
+		defer gz.Close()
+	}
+
+	if err := extractTar(tarStream, staging); err != nil {
+		os.RemoveAll(staging)
+		return "", err
+	}
+
+	// The tar reader stops at the end-of-archive marker, so hash whatever
+	// trailing bytes (record padding, gzip trailer) it left unread.
+	if _, err := io.Copy(io.Discard, br); err != nil {
+		os.RemoveAll(staging)
+		return "", err
+	}
+
+	if err := os.Rename(staging, path); err != nil {
+		os.RemoveAll(staging)
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// maybeGunzip returns a reader over the uncompressed tar stream in r,
+// wrapping it in a gzip.Reader only if it's actually gzip-compressed.
+func maybeGunzip(r *bufio.Reader) (io.Reader, error) {
+	magic, err := r.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(magic) == len(gzipMagic) && magic[0] == gzipMagic[0] && magic[1] == gzipMagic[1] {
+		return gzip.NewReader(r)
+	}
+	return r, nil
+}
+
+// openSeed returns a reader over the raw bytes of a seed payload, following
+// the scheme of seedURL.
+func openSeed(seedURL string) (io.ReadCloser, error) {
+	switch {
+	case strings.HasPrefix(seedURL, "file://"):
+		return os.Open(strings.TrimPrefix(seedURL, "file://"))
+	case strings.HasPrefix(seedURL, "http://"), strings.HasPrefix(seedURL, "https://"):
+		resp, err := http.Get(seedURL)
+		if err != nil {
+			return nil, err
+		}
+		return resp.Body, nil
+	case strings.HasPrefix(seedURL, "s3://"):
+		return openS3Seed(seedURL)
+	default:
+		return nil, fmt.Errorf("unsupported seed source: %s", seedURL)
+	}
+}
+
+// openS3Seed shells out to the aws CLI to stream an S3 object, avoiding a
+// direct dependency on the AWS SDK for what is otherwise a rarely used
+// code path.
+func openS3Seed(seedURL string) (io.ReadCloser, error) {
+	cmd := exec.Command("aws", "s3", "cp", seedURL, "-")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &cmdReader{stdout, cmd}, nil
+}
+
+// cmdReader adapts a running command's stdout into an io.ReadCloser whose
+// Close waits for the command to exit.
+type cmdReader struct {
+	io.Reader
+	cmd *exec.Cmd
+}
+
+// Close drains any output the command has left to write before waiting for
+// it to exit. A caller that stops reading early (e.g. extractTar aborting
+// on a bad tar entry) would otherwise leave "aws s3 cp" blocked writing to a
+// full pipe, and Wait would hang right along with it.
+func (c *cmdReader) Close() error {
+	io.Copy(io.Discard, c.Reader)
+	return c.cmd.Wait()
+}
+
+// extractTar unpacks a tar stream into dest, rejecting any entry whose
+// name (e.g. via "../" segments) would resolve outside of dest.
+func extractTar(r io.Reader, dest string) error {
+	cleanDest := filepath.Clean(dest)
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dest, hdr.Name)
+		if target != cleanDest && !strings.HasPrefix(target, cleanDest+string(os.PathSeparator)) {
+			return fmt.Errorf("seed: tar entry %q escapes destination %q", hdr.Name, dest)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.FileMode(hdr.Mode)); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0744); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode))
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return err
+			}
+			f.Close()
+		}
+	}
+}
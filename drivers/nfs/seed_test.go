@@ -0,0 +1,115 @@
+package nfs
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// buildTar writes a tar stream containing one entry per (name, body) pair.
+func buildTar(t *testing.T, entries map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, body := range entries {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(body))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("WriteHeader(%s): %v", name, err)
+		}
+		if _, err := tw.Write([]byte(body)); err != nil {
+			t.Fatalf("Write(%s): %v", name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractTarRejectsPathTraversal(t *testing.T) {
+	raw := buildTar(t, map[string]string{"../escape.txt": "pwned"})
+	dest := t.TempDir()
+
+	err := extractTar(bytes.NewReader(raw), dest)
+	if err == nil {
+		t.Fatal("expected an error for a tar entry escaping dest, got nil")
+	}
+
+	if _, statErr := os.Stat(filepath.Join(filepath.Dir(dest), "escape.txt")); statErr == nil {
+		t.Fatal("path-traversal entry was written outside dest")
+	}
+}
+
+func TestExtractTarWritesRegularFiles(t *testing.T) {
+	raw := buildTar(t, map[string]string{"a/b.txt": "hello"})
+	dest := t.TempDir()
+
+	if err := extractTar(bytes.NewReader(raw), dest); err != nil {
+		t.Fatalf("extractTar: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dest, "a", "b.txt"))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(got) != "hello" {
+		t.Fatalf("got %q, want %q", got, "hello")
+	}
+}
+
+func TestMaybeGunzipPassesThroughPlainTar(t *testing.T) {
+	raw := buildTar(t, map[string]string{"a.txt": "plain"})
+	br := bufio.NewReader(bytes.NewReader(raw))
+
+	r, err := maybeGunzip(br)
+	if err != nil {
+		t.Fatalf("maybeGunzip: %v", err)
+	}
+	if _, ok := r.(*gzip.Reader); ok {
+		t.Fatal("plain tar was wrapped in a gzip.Reader")
+	}
+
+	if err := extractTar(r, t.TempDir()); err != nil {
+		t.Fatalf("extractTar after maybeGunzip: %v", err)
+	}
+}
+
+func TestMaybeGunzipDecompressesGzip(t *testing.T) {
+	raw := buildTar(t, map[string]string{"a.txt": "compressed"})
+
+	var gzBuf bytes.Buffer
+	gw := gzip.NewWriter(&gzBuf)
+	if _, err := gw.Write(raw); err != nil {
+		t.Fatalf("gzip Write: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("gzip Close: %v", err)
+	}
+
+	br := bufio.NewReader(bytes.NewReader(gzBuf.Bytes()))
+	r, err := maybeGunzip(br)
+	if err != nil {
+		t.Fatalf("maybeGunzip: %v", err)
+	}
+	gz, ok := r.(*gzip.Reader)
+	if !ok {
+		t.Fatal("gzip-compressed tar was not wrapped in a gzip.Reader")
+	}
+	defer gz.Close()
+
+	dest := t.TempDir()
+	if err := extractTar(gz, dest); err != nil {
+		t.Fatalf("extractTar after maybeGunzip: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dest, "a.txt"))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(got) != "compressed" {
+		t.Fatalf("got %q, want %q", got, "compressed")
+	}
+}